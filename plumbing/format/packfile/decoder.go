@@ -2,6 +2,7 @@ package packfile
 
 import (
 	"bytes"
+	"io/ioutil"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
@@ -40,6 +41,10 @@ var (
 	ErrNonSeekable = NewError("non-seekable scanner")
 	// ErrRollback error making Rollback over a transaction after an error
 	ErrRollback = NewError("rollback error, during set error")
+	// ErrCyclicDelta is returned by DecodeWithIndex if the packfile's
+	// OFS-delta bases form a cycle, which would make a valid topological
+	// order impossible.
+	ErrCyclicDelta = NewError("cyclic OFS delta dependency")
 )
 
 // Decoder reads and decodes packfiles from an input stream.
@@ -48,9 +53,20 @@ type Decoder struct {
 	o  storer.ObjectStorer
 	tx storer.Transaction
 
+	// DeltaBaseCacheBytes bounds, in bytes of decompressed object content,
+	// the LRU cache of delta bases kept alive by DecodeWithIndex. Zero
+	// means DefaultDeltaBaseCacheBytes.
+	DeltaBaseCacheBytes int
+
 	offsetToHash map[int64]plumbing.Hash
 	hashToOffset map[plumbing.Hash]int64
 	crcs         map[plumbing.Hash]uint32
+
+	cache *deltaBaseCache
+	// deltaBases holds the offsets that DecodeWithIndex found referenced
+	// as some OFS-delta's base, the only offsets worth keeping in cache
+	// once materialized. Nil outside of a DecodeWithIndex call.
+	deltaBases map[int64]bool
 }
 
 // NewDecoder returns a new Decoder that reads from r.
@@ -78,6 +94,200 @@ func (d *Decoder) Decode() (checksum plumbing.Hash, err error) {
 	return d.s.Checksum()
 }
 
+// ObjectIndex holds the metadata a decode pass of DecodeWithIndex records
+// for a single object in a packfile: its offset, size, type, CRC-32 and,
+// for delta objects, a pointer to its base.
+type ObjectIndex struct {
+	Hash   plumbing.Hash
+	Offset int64
+	Size   int64
+	Type   plumbing.ObjectType
+	CRC32  uint32
+
+	// BaseOffset and BaseHash locate this object's delta base, set when
+	// Type is OFSDeltaObject or REFDeltaObject respectively. At most one
+	// of the two is ever set, matching the delta reference kind read from
+	// the packfile.
+	BaseOffset int64
+	BaseHash   plumbing.Hash
+}
+
+// DecodeWithIndex behaves like Decode, but runs in two passes: it first
+// records every object's offset, size, type and delta-base pointer
+// without materializing content, then replays them in dependency order
+// through a bounded delta-base cache (see DeltaBaseCacheBytes). It
+// returns the resulting index keyed by hash, usable with SetOffsets,
+// alongside the pack checksum, and requires a seekable Scanner.
+func (d *Decoder) DecodeWithIndex() (plumbing.Hash, map[plumbing.Hash]*ObjectIndex, error) {
+	if !d.s.IsSeekable {
+		return plumbing.ZeroHash, nil, ErrNonSeekable
+	}
+
+	byOffset, scanOrder, err := d.indexPass()
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	order, err := topoOrder(byOffset, scanOrder)
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	d.cache = newDeltaBaseCache(d.DeltaBaseCacheBytes)
+	d.deltaBases = referencedOFSBases(byOffset)
+	defer func() {
+		d.cache = nil
+		d.deltaBases = nil
+	}()
+
+	index := make(map[plumbing.Hash]*ObjectIndex, len(byOffset))
+	for _, offset := range order {
+		obj, err := d.recallByOffset(offset)
+		if err != nil {
+			return plumbing.ZeroHash, nil, err
+		}
+
+		entry := byOffset[offset]
+		entry.Hash = obj.Hash()
+
+		d.setOffset(entry.Hash, offset)
+		d.setCRC(entry.Hash, entry.CRC32)
+		index[entry.Hash] = entry
+	}
+
+	// indexPass left the scanner right after the last object, and each
+	// ReadObjectAt above seeks back to its own pre-call position when
+	// done, so the scanner is still there to read the trailer.
+	checksum, err := d.s.Checksum()
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	return checksum, index, nil
+}
+
+// topoOrder returns scanOrder reordered so that every OFS-delta object
+// comes after its base, using Kahn's algorithm over the BaseOffset
+// dependency graph. It returns ErrCyclicDelta if that graph isn't a DAG.
+func topoOrder(index map[int64]*ObjectIndex, scanOrder []int64) ([]int64, error) {
+	children := make(map[int64][]int64)
+	indegree := make(map[int64]int, len(scanOrder))
+	for _, offset := range scanOrder {
+		indegree[offset] = 0
+	}
+
+	for _, offset := range scanOrder {
+		base, ok := ofsBase(index, index[offset])
+		if !ok {
+			continue
+		}
+
+		children[base] = append(children[base], offset)
+		indegree[offset]++
+	}
+
+	// Regular objects and REF-delta objects have no OFS edge, so they
+	// start with indegree 0 and keep their relative scan order here.
+	queue := make([]int64, 0, len(scanOrder))
+	for _, offset := range scanOrder {
+		if indegree[offset] == 0 {
+			queue = append(queue, offset)
+		}
+	}
+
+	order := make([]int64, 0, len(scanOrder))
+	for len(queue) > 0 {
+		offset := queue[0]
+		queue = queue[1:]
+		order = append(order, offset)
+
+		for _, child := range children[offset] {
+			indegree[child]--
+			if indegree[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	if len(order) != len(scanOrder) {
+		return nil, ErrCyclicDelta
+	}
+
+	return order, nil
+}
+
+// ofsBase returns entry's OFS-delta base offset and true, if entry is an
+// OFS-delta object whose base is itself present in index.
+func ofsBase(index map[int64]*ObjectIndex, entry *ObjectIndex) (int64, bool) {
+	if entry.Type != plumbing.OFSDeltaObject {
+		return 0, false
+	}
+
+	if _, ok := index[entry.BaseOffset]; !ok {
+		return 0, false
+	}
+
+	return entry.BaseOffset, true
+}
+
+// referencedOFSBases returns the set of offsets that are the OFS-delta
+// base of some object in index.
+func referencedOFSBases(index map[int64]*ObjectIndex) map[int64]bool {
+	bases := make(map[int64]bool)
+	for _, entry := range index {
+		if base, ok := ofsBase(index, entry); ok {
+			bases[base] = true
+		}
+	}
+
+	return bases
+}
+
+// indexPass scans the whole packfile once, recording the offset, size,
+// type, CRC-32 and delta-base pointer of every object without
+// materializing its content. It returns the entries keyed by offset
+// along with the order they were found in.
+func (d *Decoder) indexPass() (map[int64]*ObjectIndex, []int64, error) {
+	_, count, err := d.s.Header()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := make(map[int64]*ObjectIndex, count)
+	order := make([]int64, 0, count)
+
+	for i := 0; i < int(count); i++ {
+		h, err := d.s.NextObjectHeader()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		size, crc, err := d.s.NextObject(ioutil.Discard)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entry := &ObjectIndex{
+			Offset: h.Offset,
+			Size:   size,
+			Type:   h.Type,
+			CRC32:  crc,
+		}
+
+		switch h.Type {
+		case plumbing.REFDeltaObject:
+			entry.BaseHash = h.Reference
+		case plumbing.OFSDeltaObject:
+			entry.BaseOffset = h.OffsetReference
+		}
+
+		index[h.Offset] = entry
+		order = append(order, h.Offset)
+	}
+
+	return index, order, nil
+}
+
 func (d *Decoder) doDecode() error {
 	_, count, err := d.s.Header()
 	if err != nil {
@@ -258,8 +468,23 @@ func (d *Decoder) setCRC(h plumbing.Hash, crc uint32) {
 }
 
 func (d *Decoder) recallByOffset(o int64) (plumbing.Object, error) {
+	if d.cache != nil {
+		if obj, ok := d.cache.Get(o); ok {
+			return obj, nil
+		}
+	}
+
 	if d.s.IsSeekable {
-		return d.ReadObjectAt(o)
+		obj, err := d.ReadObjectAt(o)
+		if err == nil && d.cache != nil && d.deltaBases[o] {
+			d.cache.Put(o, obj)
+		}
+
+		return obj, err
+	}
+
+	if d.tx == nil {
+		return nil, ErrCannotRecall
 	}
 
 	if h, ok := d.offsetToHash[o]; ok {
@@ -272,10 +497,17 @@ func (d *Decoder) recallByOffset(o int64) (plumbing.Object, error) {
 func (d *Decoder) recallByHash(h plumbing.Hash) (plumbing.Object, error) {
 	if d.s.IsSeekable {
 		if o, ok := d.hashToOffset[h]; ok {
-			return d.ReadObjectAt(o)
+			return d.recallByOffset(o)
 		}
 	}
 
+	// A REF-delta scanned before its base's offset is known to
+	// d.hashToOffset falls through to here; DecodeWithIndex never opens a
+	// transaction, so that's a recoverable error rather than a nil panic.
+	if d.tx == nil {
+		return nil, ErrCannotRecall
+	}
+
 	obj, err := d.tx.Object(plumbing.AnyObject, h)
 	if err != plumbing.ErrObjectNotFound {
 		return obj, err
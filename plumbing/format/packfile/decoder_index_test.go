@@ -0,0 +1,242 @@
+package packfile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// packObjHeader encodes a pack object's type+size header: the first byte
+// holds the type in bits 4-6 and the low 4 bits of size, with the high
+// bit set if more size bytes follow; each following byte holds 7 more
+// bits of size, least-significant group first, again flagging
+// continuation in its high bit.
+func packObjHeader(t plumbing.ObjectType, size int) []byte {
+	first := byte(t)<<4 | byte(size&0x0f)
+	size >>= 4
+
+	buf := []byte{first}
+	for size > 0 {
+		buf[len(buf)-1] |= 0x80
+		b := byte(size & 0x7f)
+		size >>= 7
+		buf = append(buf, b)
+	}
+
+	return buf
+}
+
+// ofsDistance encodes an OFS-delta's base distance using the pack
+// format's offset encoding: big-endian group order, with every
+// continued byte implicitly biased by one. This is distinct from the
+// little-endian size varints used inside a delta's instruction stream.
+func ofsDistance(n int64) []byte {
+	buf := []byte{byte(n & 0x7f)}
+	n >>= 7
+	for n > 0 {
+		n--
+		buf = append([]byte{byte(n&0x7f) | 0x80}, buf...)
+		n >>= 7
+	}
+
+	return buf
+}
+
+// deltaSize encodes a delta's base/target size field: a little-endian,
+// 7-bits-per-byte varint with the continuation bit set on every byte but
+// the last.
+func deltaSize(n int) []byte {
+	var buf []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n != 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			return buf
+		}
+	}
+}
+
+// deltaInsert builds a minimal OFS/REF-delta instruction stream that
+// reproduces target purely through insert opcodes, chunked into runs of
+// at most 127 literal bytes each. It never emits a copy opcode, so it
+// applies cleanly regardless of baseSize or the base's actual content.
+func deltaInsert(baseSize, targetSize int, target []byte) []byte {
+	buf := append(deltaSize(baseSize), deltaSize(targetSize)...)
+
+	for len(target) > 0 {
+		n := len(target)
+		if n > 127 {
+			n = 127
+		}
+
+		buf = append(buf, byte(n))
+		buf = append(buf, target[:n]...)
+		target = target[n:]
+	}
+
+	return buf
+}
+
+func deflate(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		t.Fatalf("zlib Write() error = %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("zlib Close() error = %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// packObjSpec is one object to lay out in a test pack built by buildPack.
+// For a regular object, content is its literal content and baseIdx is
+// -1. For an OFS-delta, content is the *target* content it should
+// resolve to (reproduced purely through insert opcodes, so it applies
+// regardless of the base's actual bytes) and baseIdx is the index of its
+// base within the same specs slice.
+type packObjSpec struct {
+	typ     plumbing.ObjectType
+	content []byte
+	baseIdx int
+}
+
+// buildPack assembles a minimal, spec-valid packfile from specs, written
+// in order, each OFS-delta referencing an already-written base by its
+// recorded offset.
+func buildPack(t *testing.T, specs []packObjSpec) []byte {
+	t.Helper()
+
+	const packHeaderSize = 12
+
+	offsets := make([]int64, len(specs))
+	var body bytes.Buffer
+	for i, spec := range specs {
+		offsets[i] = packHeaderSize + int64(body.Len())
+
+		if spec.baseIdx < 0 {
+			body.Write(packObjHeader(spec.typ, len(spec.content)))
+			body.Write(deflate(t, spec.content))
+			continue
+		}
+
+		baseSize := len(specs[spec.baseIdx].content)
+		delta := deltaInsert(baseSize, len(spec.content), spec.content)
+		body.Write(packObjHeader(plumbing.OFSDeltaObject, len(delta)))
+		body.Write(ofsDistance(offsets[i] - offsets[spec.baseIdx]))
+		body.Write(deflate(t, delta))
+	}
+
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(specs)))
+	pack.Write(body.Bytes())
+
+	checksum := sha1.Sum(pack.Bytes())
+	pack.Write(checksum[:])
+
+	return pack.Bytes()
+}
+
+func buildTestPack(t *testing.T) []byte {
+	return buildPack(t, []packObjSpec{
+		{typ: plumbing.BlobObject, content: []byte("hello world\n"), baseIdx: -1},
+		{typ: plumbing.BlobObject, content: []byte("hello world, and then some more appended content\n"), baseIdx: 0},
+	})
+}
+
+// buildChainTestPack lays out a base, an OFS-delta chain two deep off of
+// it (base -> delta1 -> delta2), a second delta sharing the same base as
+// delta1, and an unrelated regular object interleaved between them. A
+// single chain's scan order is always already topologically sorted, since
+// an OFS-delta's offset can only point backward to an already-written
+// base; interleaving two chains and a root object is what makes topoOrder
+// actually reorder scanOrder rather than return it unchanged.
+func buildChainTestPack(t *testing.T) []byte {
+	return buildPack(t, []packObjSpec{
+		{typ: plumbing.BlobObject, content: []byte("base content\n"), baseIdx: -1},             // 0: base
+		{typ: plumbing.BlobObject, content: []byte("base, once delta'd\n"), baseIdx: 0},        // 1: delta1, bases off 0
+		{typ: plumbing.BlobObject, content: []byte("unrelated object\n"), baseIdx: -1},         // 2: other, no base
+		{typ: plumbing.BlobObject, content: []byte("delta1, delta'd again\n"), baseIdx: 1},     // 3: delta2, bases off 1
+		{typ: plumbing.BlobObject, content: []byte("base, delta'd differently\n"), baseIdx: 0}, // 4: delta3, also bases off 0
+	})
+}
+
+// assertDecodeWithIndexMatchesDecode decodes pack through both Decode and
+// DecodeWithIndex and checks they agree on checksum, offsets and CRCs.
+func assertDecodeWithIndexMatchesDecode(t *testing.T, pack []byte) {
+	t.Helper()
+
+	decodeDecoder, err := NewDecoder(NewScanner(bytes.NewReader(pack)), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() error = %v", err)
+	}
+
+	wantChecksum, err := decodeDecoder.Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	wantOffsets := decodeDecoder.Offsets()
+	wantCRCs := decodeDecoder.CRCs()
+
+	indexDecoder, err := NewDecoder(NewScanner(bytes.NewReader(pack)), nil)
+	if err != nil {
+		t.Fatalf("NewDecoder() error = %v", err)
+	}
+
+	gotChecksum, index, err := indexDecoder.DecodeWithIndex()
+	if err != nil {
+		t.Fatalf("DecodeWithIndex() error = %v", err)
+	}
+
+	if gotChecksum != wantChecksum {
+		t.Fatalf("DecodeWithIndex() checksum = %s, want %s (from Decode())", gotChecksum, wantChecksum)
+	}
+
+	if len(index) != len(wantOffsets) {
+		t.Fatalf("DecodeWithIndex() returned %d objects, want %d", len(index), len(wantOffsets))
+	}
+
+	for h, wantOffset := range wantOffsets {
+		entry, ok := index[h]
+		if !ok {
+			t.Fatalf("DecodeWithIndex() is missing an entry for %s", h)
+		}
+
+		if entry.Offset != wantOffset {
+			t.Fatalf("DecodeWithIndex() offset for %s = %d, want %d", h, entry.Offset, wantOffset)
+		}
+
+		if entry.CRC32 != wantCRCs[h] {
+			t.Fatalf("DecodeWithIndex() CRC for %s = %x, want %x", h, entry.CRC32, wantCRCs[h])
+		}
+	}
+}
+
+// TestDecodeWithIndexMatchesDecode decodes a single base+delta pack
+// through Decode and DecodeWithIndex and checks the two-pass path
+// reconstructs the same objects as the existing streaming path.
+func TestDecodeWithIndexMatchesDecode(t *testing.T) {
+	assertDecodeWithIndexMatchesDecode(t, buildTestPack(t))
+}
+
+// TestDecodeWithIndexMatchesDecodeChain exercises a deeper, interleaved
+// OFS-delta chain - the scenario the cache and the topoOrder reordering
+// exist for - through the same real Scanner/Decode path.
+func TestDecodeWithIndexMatchesDecodeChain(t *testing.T) {
+	assertDecodeWithIndexMatchesDecode(t, buildChainTestPack(t))
+}
@@ -0,0 +1,104 @@
+package packfile
+
+import (
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func indexOf(order []int64, offset int64) int {
+	for i, o := range order {
+		if o == offset {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// TestTopoOrderForwardOFSReference builds a pack-like index where an
+// OFS-delta's base appears *after* it in scan order - the inverse of the
+// layout a well-formed pack normally has - and checks that topoOrder
+// still places the base first, proving the ordering doesn't just happen
+// to work because bases are conventionally written before their deltas.
+func TestTopoOrderForwardOFSReference(t *testing.T) {
+	index := map[int64]*ObjectIndex{
+		10: {Offset: 10, Type: plumbing.OFSDeltaObject, BaseOffset: 30},
+		20: {Offset: 20, Type: plumbing.CommitObject},
+		30: {Offset: 30, Type: plumbing.BlobObject},
+	}
+	scanOrder := []int64{10, 20, 30}
+
+	order, err := topoOrder(index, scanOrder)
+	if err != nil {
+		t.Fatalf("topoOrder() error = %v, want nil", err)
+	}
+
+	if len(order) != len(scanOrder) {
+		t.Fatalf("topoOrder() returned %d offsets, want %d", len(order), len(scanOrder))
+	}
+
+	if indexOf(order, 30) >= indexOf(order, 10) {
+		t.Fatalf("base offset 30 must come before delta offset 10 in %v", order)
+	}
+}
+
+// TestTopoOrderDeltaChain checks a chain of OFS deltas (30 <- 20 <- 10,
+// meaning 10 is a delta of 20 which is itself a delta of 30) written in
+// reverse-dependency order in the pack, and checks the whole chain comes
+// out base-first.
+func TestTopoOrderDeltaChain(t *testing.T) {
+	index := map[int64]*ObjectIndex{
+		10: {Offset: 10, Type: plumbing.OFSDeltaObject, BaseOffset: 20},
+		20: {Offset: 20, Type: plumbing.OFSDeltaObject, BaseOffset: 30},
+		30: {Offset: 30, Type: plumbing.BlobObject},
+	}
+	scanOrder := []int64{10, 20, 30}
+
+	order, err := topoOrder(index, scanOrder)
+	if err != nil {
+		t.Fatalf("topoOrder() error = %v, want nil", err)
+	}
+
+	want := []int64{30, 20, 10}
+	for i, offset := range want {
+		if order[i] != offset {
+			t.Fatalf("topoOrder() = %v, want order starting with %v", order, want)
+		}
+	}
+}
+
+// TestTopoOrderCyclicDelta checks that a cycle in the OFS-delta graph -
+// which a well-formed packfile never produces - is reported rather than
+// silently dropping entries or looping forever.
+func TestTopoOrderCyclicDelta(t *testing.T) {
+	index := map[int64]*ObjectIndex{
+		10: {Offset: 10, Type: plumbing.OFSDeltaObject, BaseOffset: 20},
+		20: {Offset: 20, Type: plumbing.OFSDeltaObject, BaseOffset: 10},
+	}
+	scanOrder := []int64{10, 20}
+
+	if _, err := topoOrder(index, scanOrder); err != ErrCyclicDelta {
+		t.Fatalf("topoOrder() error = %v, want ErrCyclicDelta", err)
+	}
+}
+
+// TestTopoOrderIgnoresUnresolvedRefDeltaBase checks that a REF-delta
+// object, whose base isn't known by offset at index time, is left as a
+// root instead of breaking the sort.
+func TestTopoOrderIgnoresUnresolvedRefDeltaBase(t *testing.T) {
+	index := map[int64]*ObjectIndex{
+		10: {Offset: 10, Type: plumbing.REFDeltaObject, BaseHash: plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")},
+		20: {Offset: 20, Type: plumbing.BlobObject},
+	}
+	scanOrder := []int64{10, 20}
+
+	order, err := topoOrder(index, scanOrder)
+	if err != nil {
+		t.Fatalf("topoOrder() error = %v, want nil", err)
+	}
+
+	if len(order) != 2 {
+		t.Fatalf("topoOrder() returned %d offsets, want 2", len(order))
+	}
+}
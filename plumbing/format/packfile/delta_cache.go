@@ -0,0 +1,86 @@
+package packfile
+
+import (
+	"container/list"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// DefaultDeltaBaseCacheBytes is the size, in bytes of decompressed object
+// content, used by DecodeWithIndex when Decoder.DeltaBaseCacheBytes is
+// left at zero.
+const DefaultDeltaBaseCacheBytes = 96 * 1024 * 1024 // 96 MB
+
+// deltaBaseCache is a bounded LRU cache of delta bases already
+// materialized during a DecodeWithIndex pass, keyed by their offset in
+// the packfile. It lets a delta chain of length N re-inflate each of its
+// bases at most once while that base stays cached, regardless of how many
+// objects further down the chain depend on it; a base evicted to stay
+// within the byte budget is read again from the packfile when next needed.
+type deltaBaseCache struct {
+	maxSize int
+	size    int
+	ll      *list.List
+	items   map[int64]*list.Element
+}
+
+type deltaBaseCacheEntry struct {
+	offset int64
+	obj    plumbing.Object
+}
+
+func newDeltaBaseCache(maxBytes int) *deltaBaseCache {
+	if maxBytes <= 0 {
+		maxBytes = DefaultDeltaBaseCacheBytes
+	}
+
+	return &deltaBaseCache{
+		maxSize: maxBytes,
+		ll:      list.New(),
+		items:   make(map[int64]*list.Element),
+	}
+}
+
+// Get returns the cached object for the given offset, if any, and moves
+// it to the front of the recency list.
+func (c *deltaBaseCache) Get(offset int64) (plumbing.Object, bool) {
+	e, ok := c.items[offset]
+	if !ok {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+	return e.Value.(*deltaBaseCacheEntry).obj, true
+}
+
+// Put caches obj under offset, evicting the least recently used entries
+// until the cache fits within maxSize again or only obj is left; an
+// object larger than maxSize on its own is still cached.
+func (c *deltaBaseCache) Put(offset int64, obj plumbing.Object) {
+	if e, ok := c.items[offset]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*deltaBaseCacheEntry).obj = obj
+		return
+	}
+
+	e := c.ll.PushFront(&deltaBaseCacheEntry{offset: offset, obj: obj})
+	c.items[offset] = e
+	c.size += int(obj.Size())
+
+	for c.size > c.maxSize && c.ll.Len() > 1 {
+		c.evictOldest()
+	}
+}
+
+func (c *deltaBaseCache) evictOldest() {
+	e := c.ll.Back()
+	if e == nil {
+		return
+	}
+
+	entry := e.Value.(*deltaBaseCacheEntry)
+	c.size -= int(entry.obj.Size())
+
+	c.ll.Remove(e)
+	delete(c.items, entry.offset)
+}
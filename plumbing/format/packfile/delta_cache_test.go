@@ -0,0 +1,68 @@
+package packfile
+
+import (
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func objectOfSize(n int64) *plumbing.MemoryObject {
+	obj := &plumbing.MemoryObject{}
+	obj.SetSize(n)
+	return obj
+}
+
+func TestDeltaBaseCacheGetPut(t *testing.T) {
+	cache := newDeltaBaseCache(1024)
+
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("Get() on empty cache returned ok = true")
+	}
+
+	obj := objectOfSize(10)
+	cache.Put(1, obj)
+
+	got, ok := cache.Get(1)
+	if !ok {
+		t.Fatal("Get() after Put() returned ok = false")
+	}
+
+	if got != plumbing.Object(obj) {
+		t.Fatal("Get() returned a different object than was Put()")
+	}
+}
+
+func TestDeltaBaseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newDeltaBaseCache(25)
+
+	cache.Put(1, objectOfSize(10))
+	cache.Put(2, objectOfSize(10))
+
+	// Touch 1 so 2 becomes the least recently used entry.
+	if _, ok := cache.Get(1); !ok {
+		t.Fatal("Get(1) returned ok = false")
+	}
+
+	// This Put pushes the cache over its 25-byte budget, which must
+	// evict offset 2 (least recently used), not offset 1.
+	cache.Put(3, objectOfSize(10))
+
+	if _, ok := cache.Get(2); ok {
+		t.Fatal("Get(2) returned ok = true, want the LRU entry to have been evicted")
+	}
+
+	if _, ok := cache.Get(1); !ok {
+		t.Fatal("Get(1) returned ok = false, want the recently used entry to survive eviction")
+	}
+
+	if _, ok := cache.Get(3); !ok {
+		t.Fatal("Get(3) returned ok = false, want the just-inserted entry to survive")
+	}
+}
+
+func TestDeltaBaseCacheDefaultSize(t *testing.T) {
+	cache := newDeltaBaseCache(0)
+	if cache.maxSize != DefaultDeltaBaseCacheBytes {
+		t.Fatalf("newDeltaBaseCache(0).maxSize = %d, want %d", cache.maxSize, DefaultDeltaBaseCacheBytes)
+	}
+}
@@ -0,0 +1,204 @@
+package packfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+var (
+	// ErrInvalidIdxHeader is returned by IndexDecoder when the input
+	// doesn't start with the v2 idx magic.
+	ErrInvalidIdxHeader = NewError("invalid idx header")
+	// ErrUnsupportedIdxVersion is returned by IndexDecoder for any idx
+	// version other than 2.
+	ErrUnsupportedIdxVersion = NewError("unsupported idx version")
+	// ErrIdxChecksumMismatch is returned by IndexDecoder when the
+	// trailing SHA-1 doesn't match the content read.
+	ErrIdxChecksumMismatch = NewError("idx checksum mismatch")
+)
+
+// IndexDecoder reads a Git packfile index (.idx) version 2 and exposes the
+// offset and CRC-32 it records for every object, so a Decoder can seed
+// Decoder.SetOffsets without a full pack decode.
+type IndexDecoder struct {
+	r io.Reader
+	h hash.Hash
+}
+
+// NewIndexDecoder returns a new IndexDecoder that reads from r.
+func NewIndexDecoder(r io.Reader) *IndexDecoder {
+	return &IndexDecoder{r: r}
+}
+
+// Decode parses the idx stream and returns, for every object it
+// describes, its pack offset and CRC-32 keyed by hash. It verifies the
+// trailing idx checksum against the content read before returning.
+func (d *IndexDecoder) Decode() (map[plumbing.Hash]int64, map[plumbing.Hash]uint32, error) {
+	d.h = sha1.New()
+
+	if err := d.readHeader(); err != nil {
+		return nil, nil, err
+	}
+
+	fanout, err := d.readFanout()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count := int(fanout[255])
+
+	hashes, err := d.readHashes(count)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crcs, err := d.readCRCs(hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offsets, overflows, err := d.readOffsets(hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.readOffsetOverflow(hashes, offsets, overflows); err != nil {
+		return nil, nil, err
+	}
+
+	if err := d.readTrailer(); err != nil {
+		return nil, nil, err
+	}
+
+	return offsets, crcs, nil
+}
+
+// readAndHash reads exactly len(buf) bytes into buf, feeding every byte
+// read into the running idx checksum.
+func (d *IndexDecoder) readAndHash(buf []byte) error {
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return err
+	}
+
+	_, err := d.h.Write(buf)
+	return err
+}
+
+func (d *IndexDecoder) readHeader() error {
+	buf := make([]byte, 8)
+	if err := d.readAndHash(buf); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(buf[:4], idxMagic) {
+		return ErrInvalidIdxHeader
+	}
+
+	if binary.BigEndian.Uint32(buf[4:]) != idxVersion {
+		return ErrUnsupportedIdxVersion
+	}
+
+	return nil
+}
+
+func (d *IndexDecoder) readFanout() (*[256]uint32, error) {
+	buf := make([]byte, 256*4)
+	if err := d.readAndHash(buf); err != nil {
+		return nil, err
+	}
+
+	var fanout [256]uint32
+	for i := range fanout {
+		fanout[i] = binary.BigEndian.Uint32(buf[i*4 : i*4+4])
+	}
+
+	return &fanout, nil
+}
+
+func (d *IndexDecoder) readHashes(count int) ([]plumbing.Hash, error) {
+	hashes := make([]plumbing.Hash, count)
+	for i := 0; i < count; i++ {
+		if err := d.readAndHash(hashes[i][:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
+
+func (d *IndexDecoder) readCRCs(hashes []plumbing.Hash) (map[plumbing.Hash]uint32, error) {
+	crcs := make(map[plumbing.Hash]uint32, len(hashes))
+
+	buf := make([]byte, 4)
+	for _, h := range hashes {
+		if err := d.readAndHash(buf); err != nil {
+			return nil, err
+		}
+
+		crcs[h] = binary.BigEndian.Uint32(buf)
+	}
+
+	return crcs, nil
+}
+
+func (d *IndexDecoder) readOffsets(hashes []plumbing.Hash) (map[plumbing.Hash]int64, []plumbing.Hash, error) {
+	offsets := make(map[plumbing.Hash]int64, len(hashes))
+	var overflows []plumbing.Hash
+
+	buf := make([]byte, 4)
+	for _, h := range hashes {
+		if err := d.readAndHash(buf); err != nil {
+			return nil, nil, err
+		}
+
+		entry := binary.BigEndian.Uint32(buf)
+		if entry&idxOffsetOverflowBit == 0 {
+			offsets[h] = int64(entry)
+			continue
+		}
+
+		// The low 31 bits index into the overflow table, read next.
+		offsets[h] = int64(entry &^ idxOffsetOverflowBit)
+		overflows = append(overflows, h)
+	}
+
+	return offsets, overflows, nil
+}
+
+func (d *IndexDecoder) readOffsetOverflow(hashes []plumbing.Hash, offsets map[plumbing.Hash]int64, overflows []plumbing.Hash) error {
+	buf := make([]byte, 8)
+	for _, h := range overflows {
+		if err := d.readAndHash(buf); err != nil {
+			return err
+		}
+
+		offsets[h] = int64(binary.BigEndian.Uint64(buf))
+	}
+
+	return nil
+}
+
+func (d *IndexDecoder) readTrailer() error {
+	packChecksum := make([]byte, 20)
+	if err := d.readAndHash(packChecksum); err != nil {
+		return err
+	}
+
+	sum := d.h.Sum(nil)
+
+	idxChecksum := make([]byte, 20)
+	if _, err := io.ReadFull(d.r, idxChecksum); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(sum, idxChecksum) {
+		return ErrIdxChecksumMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package packfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+	"sort"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// idxMagic is the 4-byte signature at the start of every version 2 idx
+// file, chosen so it can never collide with a version 1 idx, which starts
+// with the 4-byte, big-endian fanout table entry for object 0x00.
+var idxMagic = []byte{0xff, 0x74, 0x4f, 0x63}
+
+const idxVersion = 2
+
+// idxOffsetOverflowBit marks a 32-bit offset table entry as an index into
+// the 64-bit offset overflow table, rather than the offset itself.
+const idxOffsetOverflowBit = uint32(1) << 31
+
+// idxOffsetOverflowLimit is the smallest offset that can't be stored
+// directly in the 32-bit offset table and goes to the overflow table.
+const idxOffsetOverflowLimit = int64(1) << 31
+
+// IndexEncoder writes a Git packfile index (.idx) version 2: a fanout
+// table, a sorted SHA-1 table, a CRC-32 table, a 32-bit offset table with
+// a 64-bit overflow table for offsets that don't fit in 31 bits, and a
+// pack checksum and idx checksum trailer.
+type IndexEncoder struct {
+	w io.Writer
+}
+
+// NewIndexEncoder returns a new IndexEncoder that writes to w.
+func NewIndexEncoder(w io.Writer) *IndexEncoder {
+	return &IndexEncoder{w: w}
+}
+
+// Encode writes a v2 idx built from offsets and crcs, as produced by a
+// decode pass such as Decoder.DecodeWithIndex, trailed by packChecksum.
+// It returns the number of bytes written.
+func (e *IndexEncoder) Encode(
+	offsets map[plumbing.Hash]int64,
+	crcs map[plumbing.Hash]uint32,
+	packChecksum plumbing.Hash,
+) (int, error) {
+	hashes := make([]plumbing.Hash, 0, len(offsets))
+	for h := range offsets {
+		hashes = append(hashes, h)
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	h := sha1.New()
+	mw := io.MultiWriter(e.w, h)
+
+	var sz int
+	for _, step := range []func(io.Writer, []plumbing.Hash) (int, error){
+		e.encodeHeader,
+		e.encodeFanout,
+		e.encodeHashes,
+		func(w io.Writer, hashes []plumbing.Hash) (int, error) {
+			return e.encodeCRCs(w, hashes, crcs)
+		},
+		func(w io.Writer, hashes []plumbing.Hash) (int, error) {
+			return e.encodeOffsets(w, hashes, offsets)
+		},
+	} {
+		n, err := step(mw, hashes)
+		sz += n
+		if err != nil {
+			return sz, err
+		}
+	}
+
+	n, err := e.encodeTrailer(mw, packChecksum, h)
+	sz += n
+	return sz, err
+}
+
+func (e *IndexEncoder) encodeHeader(w io.Writer, _ []plumbing.Hash) (int, error) {
+	if _, err := w.Write(idxMagic); err != nil {
+		return 0, err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(idxVersion)); err != nil {
+		return len(idxMagic), err
+	}
+
+	return len(idxMagic) + 4, nil
+}
+
+func (e *IndexEncoder) encodeFanout(w io.Writer, hashes []plumbing.Hash) (int, error) {
+	var fanout [256]uint32
+	for _, h := range hashes {
+		fanout[h[0]]++
+	}
+
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+
+	for _, count := range fanout {
+		if err := binary.Write(w, binary.BigEndian, count); err != nil {
+			return 0, err
+		}
+	}
+
+	return 256 * 4, nil
+}
+
+func (e *IndexEncoder) encodeHashes(w io.Writer, hashes []plumbing.Hash) (int, error) {
+	var sz int
+	for _, h := range hashes {
+		n, err := w.Write(h[:])
+		sz += n
+		if err != nil {
+			return sz, err
+		}
+	}
+
+	return sz, nil
+}
+
+func (e *IndexEncoder) encodeCRCs(w io.Writer, hashes []plumbing.Hash, crcs map[plumbing.Hash]uint32) (int, error) {
+	for _, h := range hashes {
+		if err := binary.Write(w, binary.BigEndian, crcs[h]); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(hashes) * 4, nil
+}
+
+func (e *IndexEncoder) encodeOffsets(w io.Writer, hashes []plumbing.Hash, offsets map[plumbing.Hash]int64) (int, error) {
+	var overflow []int64
+
+	for _, h := range hashes {
+		offset := offsets[h]
+
+		var entry uint32
+		if offset >= idxOffsetOverflowLimit {
+			entry = idxOffsetOverflowBit | uint32(len(overflow))
+			overflow = append(overflow, offset)
+		} else {
+			entry = uint32(offset)
+		}
+
+		if err := binary.Write(w, binary.BigEndian, entry); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, offset := range overflow {
+		if err := binary.Write(w, binary.BigEndian, uint64(offset)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(hashes)*4 + len(overflow)*8, nil
+}
+
+func (e *IndexEncoder) encodeTrailer(w io.Writer, packChecksum plumbing.Hash, h hash.Hash) (int, error) {
+	if _, err := w.Write(packChecksum[:]); err != nil {
+		return 0, err
+	}
+
+	if _, err := e.w.Write(h.Sum(nil)); err != nil {
+		return len(packChecksum), err
+	}
+
+	return len(packChecksum) * 2, nil
+}
@@ -0,0 +1,89 @@
+package packfile
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func buildTestIndex(n int) (map[plumbing.Hash]int64, map[plumbing.Hash]uint32) {
+	offsets := make(map[plumbing.Hash]int64, n)
+	crcs := make(map[plumbing.Hash]uint32, n)
+
+	for i := 0; i < n; i++ {
+		h := plumbing.NewHash(fmt.Sprintf("%040x", i+1))
+		offsets[h] = int64(i) * 100
+		crcs[h] = uint32(i) * 7
+	}
+
+	// Force the offset overflow table to be exercised by at least one
+	// entry that doesn't fit in 31 bits.
+	big := plumbing.NewHash(fmt.Sprintf("%040x", n+1))
+	offsets[big] = int64(1) << 32
+	crcs[big] = 0xdeadbeef
+
+	return offsets, crcs
+}
+
+func TestIndexEncodeDecodeRoundTrip(t *testing.T) {
+	offsets, crcs := buildTestIndex(16)
+	packChecksum := plumbing.NewHash("0000000000000000000000000000000000000001")
+
+	var buf bytes.Buffer
+	if _, err := NewIndexEncoder(&buf).Encode(offsets, crcs, packChecksum); err != nil {
+		t.Fatalf("Encode() error = %v, want nil", err)
+	}
+
+	gotOffsets, gotCRCs, err := NewIndexDecoder(&buf).Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v, want nil", err)
+	}
+
+	if len(gotOffsets) != len(offsets) {
+		t.Fatalf("Decode() returned %d offsets, want %d", len(gotOffsets), len(offsets))
+	}
+
+	for h, want := range offsets {
+		got, ok := gotOffsets[h]
+		if !ok {
+			t.Fatalf("Decode() is missing offset for %s", h)
+		}
+
+		if got != want {
+			t.Fatalf("Decode() offset for %s = %d, want %d", h, got, want)
+		}
+	}
+
+	for h, want := range crcs {
+		if got := gotCRCs[h]; got != want {
+			t.Fatalf("Decode() CRC for %s = %x, want %x", h, got, want)
+		}
+	}
+}
+
+func TestIndexDecodeRejectsBadMagic(t *testing.T) {
+	buf := bytes.NewBuffer(make([]byte, 8))
+
+	if _, _, err := NewIndexDecoder(buf).Decode(); err != ErrInvalidIdxHeader {
+		t.Fatalf("Decode() error = %v, want ErrInvalidIdxHeader", err)
+	}
+}
+
+func TestIndexDecodeRejectsCorruptChecksum(t *testing.T) {
+	offsets, crcs := buildTestIndex(4)
+	packChecksum := plumbing.NewHash("0000000000000000000000000000000000000002")
+
+	var buf bytes.Buffer
+	if _, err := NewIndexEncoder(&buf).Encode(offsets, crcs, packChecksum); err != nil {
+		t.Fatalf("Encode() error = %v, want nil", err)
+	}
+
+	corrupt := buf.Bytes()
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	if _, _, err := NewIndexDecoder(bytes.NewReader(corrupt)).Decode(); err != ErrIdxChecksumMismatch {
+		t.Fatalf("Decode() error = %v, want ErrIdxChecksumMismatch", err)
+	}
+}
@@ -1,6 +1,7 @@
 package storer
 
 import (
+	"context"
 	"errors"
 	"io"
 
@@ -52,6 +53,15 @@ type PackfileWriter interface {
 	PackfileWriter() (io.WriteCloser, error)
 }
 
+// PackfileIndexer is a optional method for ObjectStorer, it enables a
+// backend that keeps packfiles on disk to serve object lookups from a
+// persisted index, without decoding the whole packfile.
+type PackfileIndexer interface {
+	// Index returns the pack offset and CRC-32 recorded for every object
+	// in the storer's packfile index, keyed by hash.
+	Index() (map[plumbing.Hash]int64, map[plumbing.Hash]uint32, error)
+}
+
 // ObjectIter is a generic closable interface for iterating over objects.
 type ObjectIter interface {
 	Next() (plumbing.Object, error)
@@ -118,6 +128,12 @@ func (iter *ObjectLookupIter) ForEach(cb func(plumbing.Object) error) error {
 	return ForEachIterator(iter, cb)
 }
 
+// ForEachContext behaves like ForEach, but also stops and returns
+// ctx.Err() if ctx is done before the iterator is exhausted.
+func (iter *ObjectLookupIter) ForEachContext(ctx context.Context, cb func(plumbing.Object) error) error {
+	return ForEachIteratorContext(ctx, iter, cb)
+}
+
 // Close releases any resources used by the iterator.
 func (iter *ObjectLookupIter) Close() {
 	iter.pos = len(iter.series)
@@ -239,3 +255,36 @@ func ForEachIterator(iter bareIterator, cb func(plumbing.Object) error) error {
 		}
 	}
 }
+
+// ForEachIteratorContext is a helper function to build the ForEachContext
+// method of an ObjectIterContext implementation without needing to
+// rewrite the same loop each time. It behaves like ForEachIterator, but
+// also stops and returns ctx.Err() if ctx is done before iter is
+// exhausted.
+func ForEachIteratorContext(ctx context.Context, iter bareIterator, cb func(plumbing.Object) error) error {
+	defer iter.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		obj, err := iter.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := cb(obj); err != nil {
+			if err == ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package storer
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// ParallelHint is an optional interface for ObjectStorer implementations
+// whose lookups are cheap enough (an in-memory map, say) that fanning
+// them out across goroutines would only add scheduling overhead. When a
+// storer implements ParallelHint and PreferSerial returns true,
+// NewParallelObjectLookupIter falls back to a plain ObjectLookupIter.
+type ParallelHint interface {
+	PreferSerial() bool
+}
+
+// ObjectIterContext is an optional interface for ObjectIter
+// implementations that support bounding ForEach with a context.Context,
+// stopping early with ctx.Err() if it is cancelled before the iterator is
+// exhausted.
+type ObjectIterContext interface {
+	ForEachContext(ctx context.Context, cb func(plumbing.Object) error) error
+}
+
+type parallelLookupResult struct {
+	pos int
+	obj plumbing.Object
+	err error
+}
+
+// ParallelObjectLookupIter implements ObjectIter. Like ObjectLookupIter,
+// it iterates over a series of object hashes yielding each one's
+// associated object, but it fans the underlying storage.Object calls out
+// across a pool of worker goroutines instead of resolving them one at a
+// time. Objects are still yielded in the same order as series, buffering
+// out-of-order results until it is their turn.
+//
+// The ParallelObjectLookupIter must be closed with a call to Close() when
+// it is no longer needed.
+type ParallelObjectLookupIter struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	ordered   chan parallelLookupResult
+	closeOnce sync.Once
+}
+
+// NewParallelObjectLookupIter returns an object iterator given an object
+// storage and a slice of object hashes, resolving them using workers
+// goroutines. If storage implements ParallelHint and opts out of
+// parallelism, it returns a serial NewObjectLookupIter instead.
+func NewParallelObjectLookupIter(
+	storage ObjectStorer,
+	t plumbing.ObjectType,
+	series []plumbing.Hash,
+	workers int,
+) ObjectIter {
+	if hint, ok := storage.(ParallelHint); ok && hint.PreferSerial() {
+		return NewObjectLookupIter(storage, t, series)
+	}
+
+	if workers <= 0 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	jobs := make(chan int)
+	results := make(chan parallelLookupResult, workers)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for pos := range jobs {
+				obj, err := storage.Object(t, series[pos])
+				select {
+				case results <- parallelLookupResult{pos: pos, obj: obj, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for pos := range series {
+			select {
+			case jobs <- pos:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	iter := &ParallelObjectLookupIter{
+		ctx:     ctx,
+		cancel:  cancel,
+		ordered: make(chan parallelLookupResult, workers),
+	}
+
+	go iter.reorder(results)
+
+	return iter
+}
+
+// reorder drains results as they complete and republishes them on
+// iter.ordered in series order, so Next() can keep yielding objects by
+// input position regardless of which worker finished first. It stops,
+// cancelling any in-flight workers, once it has published a result that
+// carries an error; every result before it in series order is published
+// first.
+// It also stops, without leaking, if the caller abandons the iterator
+// before series is exhausted: Close cancels iter.ctx, and a caller that
+// has stopped reading iter.ordered is exactly what trips ctx.Done here.
+func (iter *ParallelObjectLookupIter) reorder(results <-chan parallelLookupResult) {
+	defer close(iter.ordered)
+
+	pending := make(map[int]parallelLookupResult)
+	next := 0
+
+	for res := range results {
+		pending[res.pos] = res
+
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+
+			select {
+			case iter.ordered <- r:
+			case <-iter.ctx.Done():
+				return
+			}
+
+			if r.err != nil {
+				iter.cancel()
+				return
+			}
+		}
+	}
+}
+
+// Next returns the next object from the iterator, in the same order as
+// the series given to NewParallelObjectLookupIter. If the iterator has
+// reached the end it returns io.EOF. The error of the earliest failed
+// lookup in series order is propagated here, after the objects before it,
+// and cancels the remaining in-flight lookups.
+//
+// Next doesn't need to select on the iterator's context itself: once
+// Close cancels it, reorder unblocks and closes iter.ordered, which is
+// enough to unblock any read here too.
+func (iter *ParallelObjectLookupIter) Next() (plumbing.Object, error) {
+	r, ok := <-iter.ordered
+	if !ok {
+		return nil, io.EOF
+	}
+
+	return r.obj, r.err
+}
+
+// ForEach call the cb function for each object contained on this iter until
+// an error happends or the end of the iter is reached. If ErrStop is sent
+// the iteration is stop but no error is returned. The iterator is closed.
+func (iter *ParallelObjectLookupIter) ForEach(cb func(plumbing.Object) error) error {
+	return ForEachIterator(iter, cb)
+}
+
+// ForEachContext behaves like ForEach, but also stops and returns
+// ctx.Err(), cancelling any in-flight workers, if ctx is done before the
+// iterator is exhausted.
+func (iter *ParallelObjectLookupIter) ForEachContext(ctx context.Context, cb func(plumbing.Object) error) error {
+	return ForEachIteratorContext(ctx, iter, cb)
+}
+
+// Close releases any resources used by the iterator, cancelling any
+// workers still resolving objects.
+func (iter *ParallelObjectLookupIter) Close() {
+	iter.closeOnce.Do(iter.cancel)
+}
+
+type parallelMultiResult struct {
+	obj plumbing.Object
+	err error
+}
+
+// parallelMultiObjectIter implements ObjectIter by draining several
+// ObjectIter concurrently, one goroutine per sub-iterator, instead of
+// exhausting them one after another like MultiObjectIter. The relative
+// order within a single sub-iterator is preserved, but the interleaving
+// between sub-iterators is not.
+type parallelMultiObjectIter struct {
+	once    sync.Once
+	cancel  context.CancelFunc
+	results chan parallelMultiResult
+}
+
+// NewParallelMultiObjectIter returns an object iterator for the given
+// slice of iterators that drains all of them concurrently, instead of in
+// sequence like NewMultiObjectIter. Sub-iterators that implement
+// ParallelHint and opt out are still drained concurrently with the
+// others; the hint only affects per-hash lookups, not iteration.
+func NewParallelMultiObjectIter(iters []ObjectIter) ObjectIter {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	results := make(chan parallelMultiResult)
+
+	var wg sync.WaitGroup
+	wg.Add(len(iters))
+	for _, it := range iters {
+		it := it
+		go func() {
+			defer wg.Done()
+			defer it.Close()
+
+			for {
+				obj, err := it.Next()
+				if err == io.EOF {
+					return
+				}
+
+				select {
+				case results <- parallelMultiResult{obj: obj, err: err}:
+				case <-ctx.Done():
+					return
+				}
+
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return &parallelMultiObjectIter{
+		cancel:  cancel,
+		results: results,
+	}
+}
+
+// Next returns the next object yielded by any of the underlying
+// iterators. If all of them are exhausted it returns io.EOF. The first
+// error returned by any sub-iterator is propagated here and cancels the
+// rest, the same as ParallelObjectLookupIter.Next does.
+func (iter *parallelMultiObjectIter) Next() (plumbing.Object, error) {
+	r, ok := <-iter.results
+	if !ok {
+		return nil, io.EOF
+	}
+
+	if r.err != nil {
+		iter.cancel()
+	}
+
+	return r.obj, r.err
+}
+
+// ForEach call the cb function for each object contained on this iter until
+// an error happends or the end of the iter is reached. If ErrStop is sent
+// the iteration is stop but no error is returned. The iterator is closed.
+func (iter *parallelMultiObjectIter) ForEach(cb func(plumbing.Object) error) error {
+	return ForEachIterator(iter, cb)
+}
+
+// ForEachContext behaves like ForEach, but also stops and returns
+// ctx.Err(), cancelling any sub-iterators still being drained, if ctx is
+// done before the iterator is exhausted.
+func (iter *parallelMultiObjectIter) ForEachContext(ctx context.Context, cb func(plumbing.Object) error) error {
+	return ForEachIteratorContext(ctx, iter, cb)
+}
+
+// Close releases any resources used by the iterator, cancelling any
+// sub-iterators still being drained.
+func (iter *parallelMultiObjectIter) Close() {
+	iter.once.Do(iter.cancel)
+}
@@ -0,0 +1,243 @@
+package storer
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// fakeObjectStorer is a minimal ObjectStorer whose Object lookups can be
+// delayed or made to fail per hash, so tests can force out-of-order
+// completion and mid-series errors. The returned object carries its
+// lookup index (rather than relying on any particular hashing of empty
+// content) in its Size, so tests can check that series order was
+// preserved without depending on plumbing's object-hashing scheme.
+type fakeObjectStorer struct {
+	index map[plumbing.Hash]int
+	delay map[plumbing.Hash]time.Duration
+	fail  map[plumbing.Hash]error
+}
+
+func (s *fakeObjectStorer) NewObject() plumbing.Object {
+	return &plumbing.MemoryObject{}
+}
+
+func (s *fakeObjectStorer) SetObject(o plumbing.Object) (plumbing.Hash, error) {
+	return o.Hash(), nil
+}
+
+func (s *fakeObjectStorer) IterObjects(t plumbing.ObjectType) (ObjectIter, error) {
+	return nil, nil
+}
+
+func (s *fakeObjectStorer) Object(t plumbing.ObjectType, h plumbing.Hash) (plumbing.Object, error) {
+	if d, ok := s.delay[h]; ok {
+		time.Sleep(d)
+	}
+
+	if err, ok := s.fail[h]; ok {
+		return nil, err
+	}
+
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(t)
+	obj.SetSize(int64(s.index[h]))
+	return obj, nil
+}
+
+func hashesForTest(n int) []plumbing.Hash {
+	hashes := make([]plumbing.Hash, n)
+	for i := range hashes {
+		hashes[i] = plumbing.NewHash(fmt.Sprintf("%040x", i+1))
+	}
+
+	return hashes
+}
+
+func newFakeObjectStorer(hashes []plumbing.Hash) *fakeObjectStorer {
+	index := make(map[plumbing.Hash]int, len(hashes))
+	for i, h := range hashes {
+		index[h] = i
+	}
+
+	return &fakeObjectStorer{
+		index: index,
+		delay: make(map[plumbing.Hash]time.Duration),
+		fail:  make(map[plumbing.Hash]error),
+	}
+}
+
+func TestParallelObjectLookupIterPreservesOrder(t *testing.T) {
+	hashes := hashesForTest(10)
+
+	// Delay earlier hashes more than later ones, so workers finish them
+	// out of order; the iterator must still yield them in series order.
+	storage := newFakeObjectStorer(hashes)
+	for i, h := range hashes {
+		storage.delay[h] = time.Duration(len(hashes)-i) * time.Millisecond
+	}
+
+	iter := NewParallelObjectLookupIter(storage, plumbing.AnyObject, hashes, 4)
+	defer iter.Close()
+
+	for i := range hashes {
+		obj, err := iter.Next()
+		if err != nil {
+			t.Fatalf("Next() at %d: unexpected error: %s", i, err)
+		}
+
+		if int(obj.Size()) != i {
+			t.Fatalf("Next() at %d: got object for index %d, want %d", i, obj.Size(), i)
+		}
+	}
+
+	if _, err := iter.Next(); err != io.EOF {
+		t.Fatalf("Next() after series exhausted: got %v, want io.EOF", err)
+	}
+}
+
+func TestParallelObjectLookupIterPropagatesError(t *testing.T) {
+	hashes := hashesForTest(6)
+	wantErr := plumbing.ErrObjectNotFound
+
+	storage := newFakeObjectStorer(hashes)
+	storage.fail[hashes[3]] = wantErr
+
+	iter := NewParallelObjectLookupIter(storage, plumbing.AnyObject, hashes, 4)
+
+	var seen int
+	err := iter.ForEach(func(plumbing.Object) error {
+		seen++
+		return nil
+	})
+
+	if err != wantErr {
+		t.Fatalf("ForEach() error = %v, want %v", err, wantErr)
+	}
+
+	if seen != 3 {
+		t.Fatalf("ForEach() delivered %d objects before the error, want 3", seen)
+	}
+}
+
+// delayedSliceIter is a minimal ObjectIter over a fixed slice of objects,
+// sleeping delay before each one it yields, so tests can force
+// NewParallelMultiObjectIter's sub-iterator goroutines to still be
+// in-flight when the caller stops reading early.
+type delayedSliceIter struct {
+	objs  []plumbing.Object
+	delay time.Duration
+	pos   int
+}
+
+func (it *delayedSliceIter) Next() (plumbing.Object, error) {
+	if it.pos >= len(it.objs) {
+		return nil, io.EOF
+	}
+
+	time.Sleep(it.delay)
+	obj := it.objs[it.pos]
+	it.pos++
+	return obj, nil
+}
+
+func (it *delayedSliceIter) ForEach(cb func(plumbing.Object) error) error {
+	return ForEachIterator(it, cb)
+}
+
+func (it *delayedSliceIter) Close() {}
+
+func newDelayedSliceIters(n, objsPerIter int, delay time.Duration) []ObjectIter {
+	iters := make([]ObjectIter, n)
+	for i := range iters {
+		objs := make([]plumbing.Object, objsPerIter)
+		for j := range objs {
+			objs[j] = &plumbing.MemoryObject{}
+		}
+
+		iters[i] = &delayedSliceIter{objs: objs, delay: delay}
+	}
+
+	return iters
+}
+
+func TestParallelMultiObjectIterEarlyStopDoesNotLeak(t *testing.T) {
+	settle := func() int {
+		for i := 0; i < 3; i++ {
+			runtime.Gosched()
+		}
+		time.Sleep(10 * time.Millisecond)
+		return runtime.NumGoroutine()
+	}
+
+	before := settle()
+
+	for i := 0; i < 20; i++ {
+		iter := NewParallelMultiObjectIter(newDelayedSliceIters(4, 50, time.Millisecond))
+
+		var seen int
+		err := iter.ForEach(func(plumbing.Object) error {
+			seen++
+			if seen == 3 {
+				return ErrStop
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("ForEach() error = %v, want nil", err)
+		}
+	}
+
+	after := settle()
+	if after > before+2 {
+		t.Fatalf("goroutine count grew from %d to %d after repeated early-stop iteration; parallelMultiObjectIter is leaking", before, after)
+	}
+}
+
+func TestParallelObjectLookupIterEarlyStopDoesNotLeak(t *testing.T) {
+	hashes := hashesForTest(50)
+
+	storage := newFakeObjectStorer(hashes)
+	for _, h := range hashes {
+		storage.delay[h] = time.Millisecond
+	}
+
+	settle := func() int {
+		for i := 0; i < 3; i++ {
+			runtime.Gosched()
+		}
+		time.Sleep(10 * time.Millisecond)
+		return runtime.NumGoroutine()
+	}
+
+	before := settle()
+
+	for i := 0; i < 20; i++ {
+		iter := NewParallelObjectLookupIter(storage, plumbing.AnyObject, hashes, 4)
+
+		var seen int
+		err := iter.ForEach(func(plumbing.Object) error {
+			seen++
+			if seen == 3 {
+				return ErrStop
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("ForEach() error = %v, want nil", err)
+		}
+	}
+
+	after := settle()
+	if after > before+2 {
+		t.Fatalf("goroutine count grew from %d to %d after repeated early-stop iteration; reorder() is leaking", before, after)
+	}
+}